@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Cache hit/miss counters, surfaced via log lines; cheap process-lifetime
+// visibility into how well the two caches are doing.
+var (
+	embeddingCacheHits   int64
+	embeddingCacheMisses int64
+	answerCacheHits      int64
+	answerCacheMisses    int64
+)
+
+func answerCacheTTL() time.Duration {
+	value := os.Getenv("ANSWER_CACHE_TTL")
+	if value == "" {
+		return 24 * time.Hour
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid ANSWER_CACHE_TTL %q, using default 24h: %s", value, err)
+		return 24 * time.Hour
+	}
+	return ttl
+}
+
+// normalizeQuery collapses whitespace and case so paraphrased-but-identical
+// queries share a cache entry.
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+func embeddingCacheKey(model string, input string) []byte {
+	sum := sha256.Sum256([]byte(model + "\x00" + input))
+	return sum[:]
+}
+
+// answerCacheKey hashes the model, normalized query, sorted retrieval set,
+// and a hash of the conversation context fed to the model, so a cache hit
+// only happens when all four match.
+func answerCacheKey(model string, normalizedQuery string, retrievedIDs []int, contextHash string) []byte {
+	sorted := append([]int(nil), retrievedIDs...)
+	sort.Ints(sorted)
+
+	idParts := make([]string, len(sorted))
+	for i, id := range sorted {
+		idParts[i] = strconv.Itoa(id)
+	}
+
+	input := strings.Join([]string{model, normalizedQuery, strings.Join(idParts, ","), contextHash}, "\x00")
+	sum := sha256.Sum256([]byte(input))
+	return sum[:]
+}
+
+func contextHash(userContextJSON string) string {
+	sum := sha256.Sum256([]byte(userContextJSON))
+	return fmt.Sprintf("%x", sum)
+}
+
+func getCachedEmbedding(ctx context.Context, key []byte) ([]float32, bool, error) {
+	var embeddingJSON string
+	err := dbConn.QueryRow(ctx, `SELECT embedding::text FROM embedding_cache WHERE key = $1;`, key).Scan(&embeddingJSON)
+	if err != nil {
+		atomic.AddInt64(&embeddingCacheMisses, 1)
+		return nil, false, nil
+	}
+
+	var embedding []float32
+	if err := json.Unmarshal([]byte(embeddingJSON), &embedding); err != nil {
+		return nil, false, fmt.Errorf("error decoding cached embedding: %w", err)
+	}
+
+	atomic.AddInt64(&embeddingCacheHits, 1)
+	log.Printf("embedding cache hit (hits=%d misses=%d)", atomic.LoadInt64(&embeddingCacheHits), atomic.LoadInt64(&embeddingCacheMisses))
+	return embedding, true, nil
+}
+
+func putCachedEmbedding(ctx context.Context, key []byte, embedding []float32) error {
+	embeddingJSON, err := json.Marshal(embedding)
+	if err != nil {
+		return fmt.Errorf("error encoding embedding for cache: %w", err)
+	}
+
+	_, err = dbConn.Exec(ctx, `
+		INSERT INTO embedding_cache (key, embedding, created_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (key) DO NOTHING;
+	`, key, string(embeddingJSON))
+	if err != nil {
+		return fmt.Errorf("error writing embedding cache: %w", err)
+	}
+	return nil
+}
+
+func getCachedAnswer(ctx context.Context, key []byte) (string, bool, error) {
+	var answer string
+	var createdAt time.Time
+	err := dbConn.QueryRow(ctx, `
+		SELECT answer::text, created_at FROM answer_cache WHERE key = $1;
+	`, key).Scan(&answer, &createdAt)
+	if err != nil {
+		atomic.AddInt64(&answerCacheMisses, 1)
+		return "", false, nil
+	}
+
+	if time.Since(createdAt) > answerCacheTTL() {
+		// Expired: drop it so the next miss writes a fresh row.
+		dbConn.Exec(ctx, `DELETE FROM answer_cache WHERE key = $1;`, key)
+		atomic.AddInt64(&answerCacheMisses, 1)
+		return "", false, nil
+	}
+
+	_, err = dbConn.Exec(ctx, `
+		UPDATE answer_cache SET hits = hits + 1, last_used_at = NOW() WHERE key = $1;
+	`, key)
+	if err != nil {
+		log.Printf("Error bumping answer cache hit count: %s", err)
+	}
+
+	atomic.AddInt64(&answerCacheHits, 1)
+	log.Printf("answer cache hit (hits=%d misses=%d)", atomic.LoadInt64(&answerCacheHits), atomic.LoadInt64(&answerCacheMisses))
+
+	var unquoted string
+	if err := json.Unmarshal([]byte(answer), &unquoted); err != nil {
+		// Older/raw rows may not be JSON-string-encoded; fall back to the raw text.
+		return answer, true, nil
+	}
+	return unquoted, true, nil
+}
+
+func putCachedAnswer(ctx context.Context, key []byte, answer string, retrievedIDs []int) error {
+	answerJSON, err := json.Marshal(answer)
+	if err != nil {
+		return fmt.Errorf("error encoding answer for cache: %w", err)
+	}
+
+	_, err = dbConn.Exec(ctx, `
+		INSERT INTO answer_cache (key, answer, retrieved_ids, hits, created_at, last_used_at)
+		VALUES ($1, $2, $3, 0, NOW(), NOW())
+		ON CONFLICT (key) DO UPDATE SET answer = EXCLUDED.answer, retrieved_ids = EXCLUDED.retrieved_ids, last_used_at = NOW();
+	`, key, string(answerJSON), retrievedIDs)
+	if err != nil {
+		return fmt.Errorf("error writing answer cache: %w", err)
+	}
+	return nil
+}
+
+// invalidateCacheHandler drops every cached answer whose retrieval set
+// included the given qna id, so correcting a FAQ entry doesn't leave stale
+// answers being served from cache.
+func invalidateCacheHandler(res http.ResponseWriter, req *http.Request) {
+	var body struct {
+		QnaID int `json:"qna_id"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(res, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	tag, err := dbConn.Exec(context.Background(), `
+		DELETE FROM answer_cache WHERE $1 = ANY(retrieved_ids);
+	`, body.QnaID)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error invalidating cache: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(map[string]interface{}{
+		"invalidated": tag.RowsAffected(),
+	})
+}
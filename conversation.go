@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// conversationHistoryLimit mirrors the 3-pair window the old session-scoped
+// `context` lookup used.
+const conversationHistoryLimit = 6
+
+// Conversation is a row of the `conversations` table.
+type Conversation struct {
+	ID        string    `json:"id"`
+	App       string    `json:"app"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ConversationMessage is a row of the `messages` table. References records
+// which qna rows were retrieved to produce an assistant message, so clients
+// can render citations.
+type ConversationMessage struct {
+	ID             int64           `json:"id"`
+	ConversationID string          `json:"conversation_id"`
+	Role           string          `json:"role"`
+	Content        string          `json:"content"`
+	References     json.RawMessage `json:"references,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// generateConversationID returns an RFC 4122 v4 UUID for a new conversation.
+func generateConversationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate conversation ID: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+func createConversationHandler(res http.ResponseWriter, req *http.Request) {
+	sessionID, _ := req.Context().Value("session_id").(string)
+
+	var body struct {
+		App string `json:"app"`
+	}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(res, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if body.App == "" {
+		body.App = "smartstore"
+	}
+
+	conversation, err := createConversation(context.Background(), sessionID, body.App)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error creating conversation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusCreated)
+	json.NewEncoder(res).Encode(conversation)
+}
+
+func listConversationsHandler(res http.ResponseWriter, req *http.Request) {
+	sessionID, _ := req.Context().Value("session_id").(string)
+
+	rows, err := dbConn.Query(context.Background(), `
+		SELECT id, app, started_at, updated_at
+		FROM conversations
+		WHERE session_id = $1
+		ORDER BY updated_at DESC;
+	`, sessionID)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Database query error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		var c Conversation
+		if err := rows.Scan(&c.ID, &c.App, &c.StartedAt, &c.UpdatedAt); err != nil {
+			http.Error(res, fmt.Sprintf("Error scanning row: %v", err), http.StatusInternalServerError)
+			return
+		}
+		conversations = append(conversations, c)
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(conversations)
+}
+
+func getConversationHandler(res http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	sessionID, _ := req.Context().Value("session_id").(string)
+
+	var c Conversation
+	err := dbConn.QueryRow(context.Background(), `
+		SELECT id, app, started_at, updated_at FROM conversations WHERE id = $1 AND session_id = $2;
+	`, id, sessionID).Scan(&c.ID, &c.App, &c.StartedAt, &c.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		http.Error(res, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Database query error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := loadConversationMessages(context.Background(), id, 0)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error loading messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"conversation": c,
+		"messages":     messages,
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(response)
+}
+
+func deleteConversationHandler(res http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	sessionID, _ := req.Context().Value("session_id").(string)
+
+	tag, err := dbConn.Exec(context.Background(), `DELETE FROM conversations WHERE id = $1 AND session_id = $2;`, id, sessionID)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error deleting conversation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(res, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func createConversation(ctx context.Context, sessionID string, app string) (Conversation, error) {
+	id, err := generateConversationID()
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	var c Conversation
+	err = dbConn.QueryRow(ctx, `
+		INSERT INTO conversations (id, session_id, app, started_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		RETURNING id, app, started_at, updated_at;
+	`, id, sessionID, app).Scan(&c.ID, &c.App, &c.StartedAt, &c.UpdatedAt)
+	if err != nil {
+		return Conversation{}, fmt.Errorf("error inserting conversation: %w", err)
+	}
+
+	return c, nil
+}
+
+// resolveConversationID returns conversationIDParam if it is set and refers
+// to a conversation owned by sessionID, and an error if it is set but does
+// not. When conversationIDParam is empty it falls back to the most recently
+// updated conversation for sessionID, auto-creating one if none exists yet.
+func resolveConversationID(ctx context.Context, sessionID string, conversationIDParam string) (string, error) {
+	if conversationIDParam != "" {
+		owned, err := conversationBelongsToSession(ctx, conversationIDParam, sessionID)
+		if err != nil {
+			return "", err
+		}
+		if !owned {
+			return "", fmt.Errorf("conversation %q not found", conversationIDParam)
+		}
+		return conversationIDParam, nil
+	}
+
+	var id string
+	err := dbConn.QueryRow(ctx, `
+		SELECT id FROM conversations WHERE session_id = $1 ORDER BY updated_at DESC LIMIT 1;
+	`, sessionID).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != pgx.ErrNoRows {
+		return "", fmt.Errorf("error looking up conversation: %w", err)
+	}
+
+	conversation, err := createConversation(ctx, sessionID, "smartstore")
+	if err != nil {
+		return "", err
+	}
+	return conversation.ID, nil
+}
+
+// conversationBelongsToSession reports whether conversationID exists and is
+// owned by sessionID, so callers (e.g. a per-message conversation_id
+// override) can reject ids belonging to someone else's session.
+func conversationBelongsToSession(ctx context.Context, conversationID string, sessionID string) (bool, error) {
+	var exists bool
+	err := dbConn.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM conversations WHERE id = $1 AND session_id = $2);
+	`, conversationID, sessionID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking conversation ownership: %w", err)
+	}
+	return exists, nil
+}
+
+// loadConversationMessages returns the messages of a conversation ordered
+// oldest-first. A limit of 0 returns the full history.
+func loadConversationMessages(ctx context.Context, conversationID string, limit int) ([]ConversationMessage, error) {
+	var rows pgx.Rows
+	var err error
+	if limit > 0 {
+		rows, err = dbConn.Query(ctx, `
+			SELECT id, conversation_id, role, content, "references", created_at
+			FROM (
+				SELECT id, conversation_id, role, content, "references", created_at
+				FROM messages
+				WHERE conversation_id = $1
+				ORDER BY created_at DESC
+				LIMIT $2
+			) recent
+			ORDER BY created_at ASC;
+		`, conversationID, limit)
+	} else {
+		rows, err = dbConn.Query(ctx, `
+			SELECT id, conversation_id, role, content, "references", created_at
+			FROM messages
+			WHERE conversation_id = $1
+			ORDER BY created_at ASC;
+		`, conversationID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []ConversationMessage{}
+	for rows.Next() {
+		var m ConversationMessage
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.References, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning message row: %w", err)
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, nil
+}
+
+// appendConversationMessages stores the user question and assistant answer
+// for a turn, tagging the assistant message with the retrieved qna ids, and
+// bumps the conversation's updated_at. All three writes run in one
+// transaction so a failure partway through never leaves a dangling user
+// message without its reply, or a reply that never bumped updated_at.
+func appendConversationMessages(ctx context.Context, conversationID string, question string, answer string, referencedQnaIDs []int) error {
+	referencesJSON, err := json.Marshal(referencedQnaIDs)
+	if err != nil {
+		return fmt.Errorf("error marshalling references: %w", err)
+	}
+
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO messages (conversation_id, role, content, created_at)
+		VALUES ($1, 'user', $2, NOW());
+	`, conversationID, question)
+	if err != nil {
+		return fmt.Errorf("error inserting user message: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO messages (conversation_id, role, content, "references", created_at)
+		VALUES ($1, 'assistant', $2, $3, NOW());
+	`, conversationID, answer, referencesJSON)
+	if err != nil {
+		return fmt.Errorf("error inserting assistant message: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE conversations SET updated_at = NOW() WHERE id = $1;`, conversationID)
+	if err != nil {
+		return fmt.Errorf("error touching conversation: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing conversation messages: %w", err)
+	}
+
+	return nil
+}
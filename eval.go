@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// goldExample is one labelled query in a retrieval eval set: a query paired
+// with the ids of the qna rows considered relevant to it.
+type goldExample struct {
+	Query       string `json:"query"`
+	RelevantIDs []int  `json:"relevant_ids"`
+}
+
+// evalMetrics is the retrieval quality of one RETRIEVAL_MODE against a gold
+// set, averaged across every example that had at least one relevant id.
+type evalMetrics struct {
+	Mode      string  `json:"mode"`
+	K         int     `json:"k"`
+	Recall    float64 `json:"recall_at_k"`
+	MRR       float64 `json:"mrr"`
+	NDCG      float64 `json:"ndcg_at_k"`
+	Evaluated int     `json:"evaluated"`
+}
+
+// loadGoldExamples reads the newline-delimited {query, relevant_ids} eval set
+// used by `eval` and the Recall@5 regression test.
+func loadGoldExamples(path string) ([]goldExample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening gold examples %q: %w", path, err)
+	}
+	defer file.Close()
+
+	var examples []goldExample
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var ex goldExample
+		if err := json.Unmarshal([]byte(line), &ex); err != nil {
+			return nil, fmt.Errorf("error parsing gold example %q: %w", line, err)
+		}
+		examples = append(examples, ex)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading gold examples %q: %w", path, err)
+	}
+	return examples, nil
+}
+
+// evaluateMode runs retrieval in the given mode against every gold example
+// and reports Recall@k, MRR, and nDCG@k (binary relevance) averaged across
+// the examples that have at least one relevant id.
+func evaluateMode(ctx context.Context, provider LLMProvider, mode string, examples []goldExample, k int) (evalMetrics, error) {
+	os.Setenv("RETRIEVAL_MODE", mode)
+	metrics := evalMetrics{Mode: mode, K: k}
+
+	var recallSum, mrrSum, ndcgSum float64
+	for _, ex := range examples {
+		if len(ex.RelevantIDs) == 0 {
+			continue
+		}
+
+		var embeddingJSON string
+		if mode != "bm25" {
+			embedding, err := provider.Embed(ctx, ex.Query)
+			if err != nil {
+				return evalMetrics{}, fmt.Errorf("error embedding eval query %q: %w", ex.Query, err)
+			}
+			raw, err := json.Marshal(embedding)
+			if err != nil {
+				return evalMetrics{}, fmt.Errorf("error marshalling eval embedding: %w", err)
+			}
+			embeddingJSON = string(raw)
+		}
+
+		retrieved, err := retrieveRelevantQnA(ctx, ex.Query, embeddingJSON, k)
+		if err != nil {
+			return evalMetrics{}, fmt.Errorf("error retrieving for eval query %q: %w", ex.Query, err)
+		}
+
+		relevant := make(map[int]bool, len(ex.RelevantIDs))
+		for _, id := range ex.RelevantIDs {
+			relevant[id] = true
+		}
+
+		hits, rank, dcg := 0, 0, 0.0
+		for i, r := range retrieved {
+			if !relevant[r.ID] {
+				continue
+			}
+			hits++
+			if rank == 0 {
+				rank = i + 1
+			}
+			dcg += 1 / math.Log2(float64(i+2))
+		}
+
+		idealHits := len(ex.RelevantIDs)
+		if idealHits > k {
+			idealHits = k
+		}
+		idcg := 0.0
+		for i := 0; i < idealHits; i++ {
+			idcg += 1 / math.Log2(float64(i+2))
+		}
+
+		recallSum += float64(hits) / float64(len(ex.RelevantIDs))
+		if rank > 0 {
+			mrrSum += 1 / float64(rank)
+		}
+		if idcg > 0 {
+			ndcgSum += dcg / idcg
+		}
+		metrics.Evaluated++
+	}
+
+	if metrics.Evaluated > 0 {
+		metrics.Recall = recallSum / float64(metrics.Evaluated)
+		metrics.MRR = mrrSum / float64(metrics.Evaluated)
+		metrics.NDCG = ndcgSum / float64(metrics.Evaluated)
+	}
+	return metrics, nil
+}
+
+// runEvalCommand implements `<binary> eval`: it scores the retrieval
+// pipeline against a gold JSONL set, printing a single-mode summary or, with
+// --compare, a Markdown table across vector/bm25/hybrid. --out additionally
+// writes the results as JSON for downstream tooling.
+func runEvalCommand(args []string, provider LLMProvider) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	in := fs.String("in", "testdata/qna_eval.jsonl", "path to JSONL gold examples ({query, relevant_ids})")
+	out := fs.String("out", "", "write machine-readable results to this JSON file")
+	k := fs.Int("k", 5, "cutoff for Recall@k and nDCG@k")
+	compare := fs.Bool("compare", false, "evaluate vector, bm25, and hybrid side-by-side")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	examples, err := loadGoldExamples(*in)
+	if err != nil {
+		return err
+	}
+
+	modes := []string{retrievalModeFromEnv()}
+	if *compare {
+		modes = []string{"vector", "bm25", "hybrid"}
+	}
+
+	ctx := context.Background()
+	results := make([]evalMetrics, 0, len(modes))
+	for _, mode := range modes {
+		m, err := evaluateMode(ctx, provider, mode, examples, *k)
+		if err != nil {
+			return err
+		}
+		results = append(results, m)
+	}
+
+	if len(results) > 1 {
+		printEvalTable(results)
+	} else {
+		m := results[0]
+		fmt.Printf("mode=%s Recall@%d=%.4f MRR=%.4f nDCG@%d=%.4f (n=%d)\n", m.Mode, m.K, m.Recall, m.MRR, m.K, m.NDCG, m.Evaluated)
+	}
+
+	if *out != "" {
+		payload, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding eval results: %w", err)
+		}
+		if err := os.WriteFile(*out, payload, 0644); err != nil {
+			return fmt.Errorf("error writing %q: %w", *out, err)
+		}
+	}
+	return nil
+}
+
+func printEvalTable(results []evalMetrics) {
+	fmt.Printf("| mode | Recall@%d | MRR | nDCG@%d | n |\n", results[0].K, results[0].K)
+	fmt.Println("|---|---|---|---|---|")
+	for _, m := range results {
+		fmt.Printf("| %s | %.4f | %.4f | %.4f | %d |\n", m.Mode, m.Recall, m.MRR, m.NDCG, m.Evaluated)
+	}
+}
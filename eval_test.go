@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TestHybridRecallAtLeastVector is the regression gate from chunk0-7: hybrid
+// retrieval must never score worse than vector-only retrieval on the seed
+// eval set. It is a real regression gate, not an optional check, so it fails
+// loudly (rather than skipping) when it cannot reach the live qna table it
+// needs: set DB_HOST/DB_PORT/DB_USER/DB_PASSWORD to a Postgres+pgvector
+// instance seeded with migrations/ and run this from a CI job that has one.
+func TestHybridRecallAtLeastVector(t *testing.T) {
+	host := os.Getenv("DB_HOST")
+	if host == "" {
+		t.Fatal("DB_HOST not set: this regression gate requires a live Postgres+pgvector instance " +
+			"seeded via migrations/, configured with DB_HOST/DB_PORT/DB_USER/DB_PASSWORD; it cannot be skipped")
+	}
+
+	dbConnStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), host, os.Getenv("DB_PORT"), "coxwave")
+	pool, err := pgxpool.New(context.Background(), dbConnStr)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v", err)
+	}
+	defer pool.Close()
+	dbConn = pool
+
+	provider, err := newLLMProvider()
+	if err != nil {
+		t.Fatalf("failed to configure LLM provider: %v", err)
+	}
+
+	examples, err := loadGoldExamples("testdata/qna_eval.jsonl")
+	if err != nil {
+		t.Fatalf("failed to load gold examples: %v", err)
+	}
+
+	ctx := context.Background()
+	vectorMetrics, err := evaluateMode(ctx, provider, "vector", examples, 5)
+	if err != nil {
+		t.Fatalf("vector eval failed: %v", err)
+	}
+	hybridMetrics, err := evaluateMode(ctx, provider, "hybrid", examples, 5)
+	if err != nil {
+		t.Fatalf("hybrid eval failed: %v", err)
+	}
+
+	if hybridMetrics.Recall < vectorMetrics.Recall {
+		t.Errorf("hybrid Recall@5 (%.4f) regressed below vector Recall@5 (%.4f)", hybridMetrics.Recall, vectorMetrics.Recall)
+	}
+}
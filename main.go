@@ -1,13 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -15,7 +13,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 )
 
@@ -27,7 +25,11 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-var dbConn *pgx.Conn
+// dbConn is a connection pool, not a single connection: retrieveRelevantQnA
+// runs its vector and BM25 legs concurrently, and chatHandler can have
+// several handleRAG calls in flight on one connection, so the shared handle
+// must tolerate concurrent use (a bare *pgx.Conn does not).
+var dbConn *pgxpool.Pool
 
 func main() {
 	err := godotenv.Load(".env")
@@ -43,11 +45,25 @@ func main() {
 	dbname := "coxwave"
 
 	dbConnStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", user, password, host, dbPort, dbname)
-	dbConn, err = pgx.Connect(context.Background(), dbConnStr)
+	dbConn, err = pgxpool.New(context.Background(), dbConnStr)
 	if err != nil {
 		log.Fatalf("Unable to connect to database: %v\n", err)
 	}
-	defer dbConn.Close(context.Background())
+	defer dbConn.Close()
+
+	llmProvider, err := newLLMProvider()
+	if err != nil {
+		log.Fatalf("Failed to configure LLM provider: %v", err)
+	}
+
+	// `<binary> eval ...` runs the retrieval evaluation harness instead of
+	// starting the server; see eval.go.
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		if err := runEvalCommand(os.Args[2:], llmProvider); err != nil {
+			log.Fatalf("eval: %v", err)
+		}
+		return
+	}
 
 	// Route
 	mux := mux.NewRouter()
@@ -60,7 +76,14 @@ func main() {
 	})
 	mux.HandleFunc("/ping", pingHandler).Methods("GET")
 	mux.HandleFunc("/history", chatHistoryHandler).Methods("GET")
-	mux.HandleFunc("/chat", chatHandler).Methods("GET")
+	mux.HandleFunc("/conversations", listConversationsHandler).Methods("GET")
+	mux.HandleFunc("/conversations", createConversationHandler).Methods("POST")
+	mux.HandleFunc("/conversations/{id}", getConversationHandler).Methods("GET")
+	mux.HandleFunc("/conversations/{id}", deleteConversationHandler).Methods("DELETE")
+	mux.HandleFunc("/chat", func(w http.ResponseWriter, r *http.Request) {
+		chatHandler(w, r, llmProvider)
+	}).Methods("GET")
+	mux.HandleFunc("/cache/invalidate", invalidateCacheHandler).Methods("POST")
 
 	// Server Runner
 	log.Printf("Starting server on port %s", serverPort)
@@ -93,7 +116,7 @@ func SessionMiddleware(next http.Handler) http.Handler {
 				Name:     "session_id",
 				Value:    sessionID,
 				Path:     "/",
-				HttpOnly: true, 
+				HttpOnly: true,
 				Secure:   true,
 				MaxAge:   3600,
 			})
@@ -118,6 +141,16 @@ func generateSessionID() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// generateMessageID returns a short id used to correlate a WebSocket query
+// with its delta/done/error frames and "cancel" requests.
+func generateMessageID() (string, error) {
+	bytes := make([]byte, 8)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate message ID: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
 
 func chatHistoryHandler(res http.ResponseWriter, req *http.Request) {
 	sessionID, ok := req.Context().Value("session_id").(string)
@@ -170,311 +203,120 @@ func chatHistoryHandler(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func chatHandler(res http.ResponseWriter, req *http.Request) {
-	conn, err := upgrader.Upgrade(res, req, nil)
+// handleRAG runs retrieval, streams the GPT answer token-by-token through
+// onDelta as it is generated (an answer-cache hit is passed to onDelta once,
+// whole), and returns the fully assembled answer, the two
+// follow-up recommendations, and the ids of the qna rows that were retrieved
+// for this turn (so clients can render citations). query is the user's query
+// text (not a raw client frame). ctx governs the in-flight OpenAI request, so
+// cancelling it (e.g. on a client "cancel" frame) stops the stream early.
+func handleRAG(ctx context.Context, provider LLMProvider, conversationID string, query string, onDelta func(string)) (string, string, string, []int, error) {
+	// Generate embedding for query, reusing a cached embedding when this
+	// exact (model, input) pair has been seen before.
+	embKey := embeddingCacheKey(provider.EmbeddingModelID(), query)
+	queryEmbedding, cached, err := getCachedEmbedding(ctx, embKey)
 	if err != nil {
-		log.Printf("WebSocket Upgrade failed: %s", err)
-		return
+		log.Printf("Error reading embedding cache: %s", err)
 	}
-	defer conn.Close()
-
-	log.Println("WebSocket connection established")
-
-	session_id, ok := req.Context().Value("session_id").(string)
-	if !ok || session_id == "" {
-		log.Println("Failed to retrieve session ID from context")
-	}
-
-	for {
-		messageType, message, err := conn.ReadMessage()
+	if !cached {
+		queryEmbedding, err = provider.Embed(ctx, query)
 		if err != nil {
-			log.Printf("Error reading message: %s", err)
-			break
+			return "", "", "", nil, fmt.Errorf("error generating embedding: %w", err)
 		}
-		log.Printf("Received: %s", message)
-		
-		// Process RAG (Vector Search and Generation)
-		response, err := handleRAG(string(message), session_id)
-		if err != nil {
-			log.Printf("Error in RAG process: %s", err)
-			conn.WriteMessage(messageType, []byte("Error processing your query"))
-			continue
-		}
-
-		// Send the response back to the client
-		err = conn.WriteMessage(messageType, []byte(response))
-		if err != nil {
-			log.Printf("Error writing message: %s", err)
-			break
+		if err := putCachedEmbedding(ctx, embKey, queryEmbedding); err != nil {
+			log.Printf("Error writing embedding cache: %s", err)
 		}
 	}
-}
-
-func handleRAG(query string, session_id string) (string, error) {
-	// Generate embedding for query
-	queryEmbedding, err := generateEmbedding(query)
-	if err != nil {
-		return "", fmt.Errorf("error generating embedding: %w", err)
-	}
 
 	embeddingJSON, err := json.Marshal(queryEmbedding)
 	if err != nil {
-		return "", fmt.Errorf("error marshalling embedding: %w", err)
+		return "", "", "", nil, fmt.Errorf("error marshalling embedding: %w", err)
 	}
 
-	// vector search in the database
-	relevantRows, err := dbConn.Query(context.Background(), `
-        SELECT question, answer
-		FROM qna
-		WHERE embedding <-> $1 < 1
-		ORDER BY embedding <-> $1
-		LIMIT 5;`, string(embeddingJSON))
+	// Retrieve the qna rows relevant to this query (vector, BM25, or both
+	// fused with reciprocal rank fusion, per RETRIEVAL_MODE).
+	retrieved, err := retrieveRelevantQnA(ctx, query, string(embeddingJSON), 5)
 	if err != nil {
-		return "", fmt.Errorf("error performing vector search: %w", err)
+		return "", "", "", nil, err
 	}
-	defer relevantRows.Close()
 
 	var relevantResults []map[string]string
-	for relevantRows.Next() {
-		var question, answer string
-		if err := relevantRows.Scan(&question, &answer); err != nil {
-			return "", fmt.Errorf("error scanning row: %w", err)
-		}
-		relevantResults = append(relevantResults, map[string]string{"question": question, "answer": answer})
+	var qnaIDs []int
+	for _, r := range retrieved {
+		relevantResults = append(relevantResults, map[string]string{"question": r.Question, "answer": r.Answer})
+		qnaIDs = append(qnaIDs, r.ID)
 	}
 	log.Printf("검색 결과:  %s", relevantResults)
-	
-	// Exception control for retrieval
-	if len(relevantResults) < 5 {
-		words := strings.Fields(query)
-
-		if len(words) > 1 {
-			partialQuery := strings.Join(words[:len(words)-1], " ")
-
-			regexRows, err := dbConn.Query(context.Background(), `
-                SELECT question, answer
-				FROM qna
-				WHERE question ~* $1
-				LIMIT $2;`, fmt.Sprintf(".*%s.*", partialQuery), 5-len(relevantResults))
-			if err != nil {
-				return "", fmt.Errorf("error performing regex search: %w", err)
-			}
-			defer regexRows.Close()
-
-			for regexRows.Next() {
-				var question, answer string
-				if err := regexRows.Scan(&question, &answer); err != nil {
-					return "", fmt.Errorf("error scanning regex row: %w", err)
-				}
-				relevantResults = append(relevantResults, map[string]string{"question": question, "answer": answer})
-			}
-		}
-	}
 
 	// Combine results for GPT
 	relevant, err := json.Marshal(relevantResults)
 	if err != nil {
-		return "", fmt.Errorf("error marshalling RAG results: %w", err)
+		return "", "", "", nil, fmt.Errorf("error marshalling RAG results: %w", err)
 	}
 
-	contextRows, err := dbConn.Query(context.Background(), `
-        SELECT question, answer
-		FROM (
-			SELECT question, answer
-			FROM context
-			WHERE session_id = $1
-			ORDER BY created_at DESC 
-			LIMIT 3
-		) subquery
-		ORDER BY created_at ASC;
-	`, session_id)
-	defer contextRows.Close()
+	conversationMessages, err := loadConversationMessages(ctx, conversationID, conversationHistoryLimit)
+	if err != nil {
+		return "", "", "", nil, fmt.Errorf("error loading conversation history: %w", err)
+	}
 
 	var contextResults []map[string]string
-	for contextRows.Next() {
-		var question, answer string
-		if err := contextRows.Scan(&question, &answer); err != nil {
-			return "", fmt.Errorf("error scanning row: %w", err)
-		}
-		contextResults = append(contextResults, map[string]string{"question": question, "answer": answer})
+	for _, m := range conversationMessages {
+		contextResults = append(contextResults, map[string]string{"role": m.Role, "content": m.Content})
 	}
 
 	user_context, err := json.Marshal(contextResults)
 	if err != nil {
-		return "", fmt.Errorf("error marshalling RAG results: %w", err)
+		return "", "", "", nil, fmt.Errorf("error marshalling RAG results: %w", err)
 	}
 	log.Printf("히스토리 결과:  %s", contextResults)
-	// Generate GPT response using RAG results
-	gptResponse, err := callGPT(query, string(relevant), string(user_context))
-	if err != nil {
-		return "", fmt.Errorf("error generating GPT response: %w", err)
-	}
 
-	var queryData map[string]string
-	err = json.Unmarshal([]byte(query), &queryData)
+	// The answer cache key folds in the chat model, the normalized query, the
+	// retrieved qna ids, and the conversation context, so a hit only occurs
+	// when all of those line up with a prior turn.
+	ansKey := answerCacheKey(provider.ChatModelID(), normalizeQuery(query), qnaIDs, contextHash(string(user_context)))
+	gptResponse, cachedAnswer, err := getCachedAnswer(ctx, ansKey)
 	if err != nil {
-		return "", fmt.Errorf("error parsing query JSON: %w", err)
-	}
-
-	actualQuery, exists := queryData["query"]
-	if !exists {
-		return "", fmt.Errorf("query field missing in JSON")
-	}
-
-	_, err = dbConn.Exec(context.Background(), `
-    INSERT INTO context (session_id, question, answer, created_at)
-    VALUES ($1, $2, $3, NOW());`, session_id, actualQuery, gptResponse)
-
-	if err != nil {
-		return "", fmt.Errorf("error inserting into context table: %w", err)
-	}
-
-	return gptResponse, nil
-}
-
-type EmbeddingRequest struct {
-	Model string `json:"model"`
-	Input string `json:"input"`
-}
-
-func generateEmbedding(query string) ([]float32, error) {
-	openAIKey := os.Getenv("OPENAI_API_KEY")
-	if openAIKey == "" {
-		return nil, fmt.Errorf("OpenAI API key not set in environment variables")
-	}
-
-	url := "https://api.openai.com/v1/embeddings"
-	requestBody := EmbeddingRequest{
-		Model: "text-embedding-3-small",
-		Input: query,
-	}
-
-	payload, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("error encoding JSON payload: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(payload)))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+openAIKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error sending request to OpenAI: %w", err)
-	}
-	defer resp.Body.Close()
+		log.Printf("Error reading answer cache: %s", err)
+	}
+	if cachedAnswer {
+		onDelta(gptResponse)
+	} else {
+		// Stream the GPT response using RAG results, forwarding each delta as
+		// it arrives and accumulating the full raw response for
+		// storage/parsing.
+		messages := buildGPTMessages(query, string(relevant), string(user_context))
+		deltas, streamErrs := provider.ChatStream(ctx, messages, ChatOptions{MaxTokens: 2000, Temperature: 0.7})
+		var rawAnswer strings.Builder
+		for delta := range deltas {
+			rawAnswer.WriteString(delta)
+			onDelta(delta)
+		}
+		if err := <-streamErrs; err != nil {
+			return "", "", "", nil, fmt.Errorf("error generating GPT response: %w", err)
+		}
+		gptResponse = rawAnswer.String()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("OpenAI API error: %s", body)
+		if err := putCachedAnswer(ctx, ansKey, gptResponse, qnaIDs); err != nil {
+			log.Printf("Error writing answer cache: %s", err)
+		}
 	}
 
-	var response struct {
-		Data []struct {
-			Embedding []float32 `json:"embedding"`
-		} `json:"data"`
-	}
-	err = json.NewDecoder(resp.Body).Decode(&response)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding response: %w", err)
+	if err := appendConversationMessages(ctx, conversationID, query, gptResponse, qnaIDs); err != nil {
+		return "", "", "", nil, err
 	}
 
-	if len(response.Data) == 0 {
-		return nil, fmt.Errorf("no embedding data returned")
+	var parsed GPTAnswer
+	if err := json.Unmarshal([]byte(gptResponse), &parsed); err != nil {
+		log.Printf("Error parsing GPT answer JSON: %s", err)
+		return gptResponse, "", "", qnaIDs, nil
 	}
 
-	return response.Data[0].Embedding, nil
-}
-
-type GPTRequest struct {
-	Model    string         `json:"model"`
-	Messages []GPTMessage   `json:"messages"`
-	MaxTokens int           `json:"max_tokens"`
-	Temperature float32     `json:"temperature"`
+	return parsed.Answer, parsed.Recommend1, parsed.Recommend2, qnaIDs, nil
 }
 
+// GPTMessage is a single chat message in the OpenAI-style role/content shape
+// shared by every LLMProvider implementation.
 type GPTMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
-
-func callGPT(query string, relevant_information string, user_context string) (string, error) {
-	openAIKey := os.Getenv("OPENAI_API_KEY")
-	if openAIKey == "" {
-		return "", fmt.Errorf("OpenAI API key not set in environment variables")
-	}
-
-	url := "https://api.openai.com/v1/chat/completions"
-	requestBody := GPTRequest{
-		Model: "gpt-4o-mini",
-		Messages: []GPTMessage{
-			{
-				Role: "system",
-				Content: `당신은 네이버 스마트스토어와 관련된 질문에 응답하는 'FAQ 챗봇'입니다. 
-
-				당신의 주요 역할:
-				1. 사용자가 질문한 내용(` + "`user_query`" + `)을 이해하고, 
-				2. 제공된 데이터(` + "`relevant_information`" + ` 및 ` + "`user_context`" + `)를 바탕으로 사용자 질문에 정확히 답변합니다.
-				
-				### 응답 규칙:
-				1. 사용자 질문이 제공된 ` + "`relevant_information`" + ` 데이터와 관련이 있으면, 데이터를 기반으로 답변을 생성하세요.
-				2. 질문에 대해 제공된 데이터에서 찾을 수 없는 경우에도, 데이터를 종합적으로 분석하고 유사성을 활용하여 가능한 최선의 답변을 제공하세요.
-				3. 사용자의 질문이 네이버 스마트스토어와 관련이 없다고 확실히 판단되면, 아래와 같은 응답을 제공합니다:
-				- '저는 네이버 스마트스토어 FAQ를 위한 챗봇입니다. 관련된 질문을 부탁드립니다.'
-				4. 데이터(` + "`relevant_information`" + ` 또는 ` + "`user_context`" + `)에서 제공된 정보와 질문의 단어 또는 의미적 유사성이 명확하다면, 이를 우선적으로 활용하세요.
-				5. recommend1과 recommend2 응답에서는 질문에 대한 응답 이후 사용자가 추가적으로 궁금해할 수 있는 부분에 대한 간략한 질문이어야 합니다.
-				
-				응답은 반드시 응답은 반드시 다음 JSON 형식으로 응답을 제공해주세요. {"answer": 질문에 대한 한글 응답, "recommend1": 질문에 대한 응답 이후 궁금할 내용 1, "recommend2": 질문에 대한 응답 이후 궁금할 내용 2}`,
-			},
-			{Role: "user", Content: fmt.Sprintf(`{user_query: %s,\nrelevant_information: %s,\nuser_context: %s} 응답은 반드시 다음 JSON 형식으로 응답을 제공해주세요. {"answer": 질문에 대한 한글 응답, "recommend1": 질문에 대한 응답 이후 궁금할 내용 1, "recommend2": 질문에 대한 응답 이후 궁금할 내용 2}`, query, relevant_information, user_context)},
-		},
-		MaxTokens:   2000,
-		Temperature: 0.7,
-	}
-
-	payload, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("error encoding GPT request: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
-	if err != nil {
-		return "", fmt.Errorf("error creating GPT request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+openAIKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending GPT request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("GPT API error: %s", body)
-	}
-
-	var gptResponse struct {
-		Choices []struct {
-			Message GPTMessage `json:"message"`
-		} `json:"choices"`
-	}
-	err = json.NewDecoder(resp.Body).Decode(&gptResponse)
-	if err != nil {
-		return "", fmt.Errorf("error decoding GPT response: %w", err)
-	}
-
-	if len(gptResponse.Choices) == 0 {
-		return "", fmt.Errorf("no GPT response received")
-	}
-
-	return gptResponse.Choices[0].Message.Content, nil
-}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// GPTAnswer is the JSON shape the system prompt asks the model to reply with.
+type GPTAnswer struct {
+	Answer     string `json:"answer"`
+	Recommend1 string `json:"recommend1"`
+	Recommend2 string `json:"recommend2"`
+}
+
+// ChatOptions carries the generation knobs that are independent of which
+// LLMProvider is configured.
+type ChatOptions struct {
+	MaxTokens   int
+	Temperature float32
+}
+
+// EmbeddingProvider turns a text query into its vector representation.
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, input string) ([]float32, error)
+}
+
+// LLMProvider is the full chat + embedding backend used by handleRAG. It is
+// selected once at startup (see newLLMProvider) so the rest of the code never
+// talks to a specific vendor's API directly.
+type LLMProvider interface {
+	EmbeddingProvider
+	Chat(ctx context.Context, messages []GPTMessage, opts ChatOptions) (string, error)
+	ChatStream(ctx context.Context, messages []GPTMessage, opts ChatOptions) (<-chan string, <-chan error)
+
+	// EmbeddingModelID and ChatModelID identify the configured model/
+	// deployment so cache keys don't collide across models.
+	EmbeddingModelID() string
+	ChatModelID() string
+}
+
+// newLLMProvider builds the LLMProvider selected by the LLM_PROVIDER env var
+// (defaulting to "openai"): "openai", "azure", "ollama", or "copilot-proxy"
+// (an OpenAI-compatible proxy reached through LLM_BASE_URL).
+func newLLMProvider() (LLMProvider, error) {
+	model := firstNonEmpty(os.Getenv("LLM_MODEL"), "gpt-4o-mini")
+	embeddingModel := firstNonEmpty(os.Getenv("EMBEDDING_MODEL"), "text-embedding-3-small")
+
+	switch providerName := os.Getenv("LLM_PROVIDER"); providerName {
+	case "", "openai":
+		return &openAIProvider{
+			apiKey:         os.Getenv("OPENAI_API_KEY"),
+			baseURL:        firstNonEmpty(os.Getenv("LLM_BASE_URL"), "https://api.openai.com"),
+			model:          model,
+			embeddingModel: embeddingModel,
+		}, nil
+	case "copilot-proxy":
+		baseURL := os.Getenv("LLM_BASE_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("LLM_BASE_URL is required for LLM_PROVIDER=copilot-proxy")
+		}
+		return &openAIProvider{
+			apiKey:         os.Getenv("OPENAI_API_KEY"),
+			baseURL:        baseURL,
+			model:          model,
+			embeddingModel: embeddingModel,
+		}, nil
+	case "azure":
+		baseURL := os.Getenv("LLM_BASE_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("LLM_BASE_URL is required for LLM_PROVIDER=azure")
+		}
+		return &azureProvider{
+			apiKey:              os.Getenv("OPENAI_API_KEY"),
+			baseURL:             strings.TrimRight(baseURL, "/"),
+			apiVersion:          firstNonEmpty(os.Getenv("AZURE_API_VERSION"), "2024-02-01"),
+			deployment:          model,
+			embeddingDeployment: embeddingModel,
+		}, nil
+	case "ollama":
+		return &ollamaProvider{
+			baseURL:        firstNonEmpty(os.Getenv("LLM_BASE_URL"), "http://localhost:11434"),
+			model:          model,
+			embeddingModel: embeddingModel,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q", providerName)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// buildGPTMessages builds the system/user message pair shared by every
+// provider so the prompt stays identical no matter which backend answers it.
+func buildGPTMessages(query string, relevant_information string, user_context string) []GPTMessage {
+	return []GPTMessage{
+		{
+			Role: "system",
+			Content: `당신은 네이버 스마트스토어와 관련된 질문에 응답하는 'FAQ 챗봇'입니다.
+
+				당신의 주요 역할:
+				1. 사용자가 질문한 내용(` + "`user_query`" + `)을 이해하고,
+				2. 제공된 데이터(` + "`relevant_information`" + ` 및 ` + "`user_context`" + `)를 바탕으로 사용자 질문에 정확히 답변합니다.
+
+				### 응답 규칙:
+				1. 사용자 질문이 제공된 ` + "`relevant_information`" + ` 데이터와 관련이 있으면, 데이터를 기반으로 답변을 생성하세요.
+				2. 질문에 대해 제공된 데이터에서 찾을 수 없는 경우에도, 데이터를 종합적으로 분석하고 유사성을 활용하여 가능한 최선의 답변을 제공하세요.
+				3. 사용자의 질문이 네이버 스마트스토어와 관련이 없다고 확실히 판단되면, 아래와 같은 응답을 제공합니다:
+				- '저는 네이버 스마트스토어 FAQ를 위한 챗봇입니다. 관련된 질문을 부탁드립니다.'
+				4. 데이터(` + "`relevant_information`" + ` 또는 ` + "`user_context`" + `)에서 제공된 정보와 질문의 단어 또는 의미적 유사성이 명확하다면, 이를 우선적으로 활용하세요.
+				5. recommend1과 recommend2 응답에서는 질문에 대한 응답 이후 사용자가 추가적으로 궁금해할 수 있는 부분에 대한 간략한 질문이어야 합니다.
+
+				응답은 반드시 응답은 반드시 다음 JSON 형식으로 응답을 제공해주세요. {"answer": 질문에 대한 한글 응답, "recommend1": 질문에 대한 응답 이후 궁금할 내용 1, "recommend2": 질문에 대한 응답 이후 궁금할 내용 2}`,
+		},
+		{Role: "user", Content: fmt.Sprintf(`{user_query: %s,\nrelevant_information: %s,\nuser_context: %s} 응답은 반드시 다음 JSON 형식으로 응답을 제공해주세요. {"answer": 질문에 대한 한글 응답, "recommend1": 질문에 대한 응답 이후 궁금할 내용 1, "recommend2": 질문에 대한 응답 이후 궁금할 내용 2}`, query, relevant_information, user_context)},
+	}
+}
+
+// decodeStreamLine turns one line of a provider's streaming response body
+// into a delta: content is forwarded to the caller if non-empty, done signals
+// the stream is finished, and skip marks a line that carries no chunk (e.g. an
+// SSE comment or keep-alive) and should be ignored without ending the stream.
+type decodeStreamLine func(line string) (content string, done bool, skip bool, err error)
+
+// consumeChatStream scans newline-delimited chunks from body via decodeLine
+// and forwards their content to deltas, stopping on decodeLine reporting
+// done, a scan error, or ctx being cancelled. Cancellation is reported via
+// errs (not left silent) so a cancelled stream is never mistaken by a caller
+// for a successful one. It does not close deltas/errs; the caller owns both.
+func consumeChatStream(ctx context.Context, body io.Reader, deltas chan<- string, errs chan<- error, decodeLine decodeStreamLine) {
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		content, done, skip, err := decodeLine(line)
+		if err != nil {
+			errs <- err
+			return
+		}
+		if skip {
+			continue
+		}
+		if content != "" {
+			select {
+			case deltas <- content:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+		if done {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs <- fmt.Errorf("error reading GPT stream: %w", err)
+	}
+}
+
+// --- openAIProvider: talks to api.openai.com, or any OpenAI-compatible
+// endpoint (self-hosted proxy, Copilot proxy, ...) when baseURL is overridden.
+
+type openAIProvider struct {
+	apiKey         string
+	baseURL        string
+	model          string
+	embeddingModel string
+}
+
+func (p *openAIProvider) EmbeddingModelID() string { return p.embeddingModel }
+func (p *openAIProvider) ChatModelID() string      { return p.model }
+
+func (p *openAIProvider) Embed(ctx context.Context, input string) ([]float32, error) {
+	if p.apiKey == "" {
+		return nil, fmt.Errorf("OpenAI API key not set in environment variables")
+	}
+
+	payload, err := json.Marshal(struct {
+		Model string `json:"model"`
+		Input string `json:"input"`
+	}{Model: p.embeddingModel, Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding JSON payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/v1/embeddings", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to OpenAI: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("OpenAI API error: %s", body)
+	}
+
+	var response struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("no embedding data returned")
+	}
+
+	return response.Data[0].Embedding, nil
+}
+
+func (p *openAIProvider) chatRequest(messages []GPTMessage, opts ChatOptions, stream bool) ([]byte, error) {
+	return json.Marshal(struct {
+		Model       string       `json:"model"`
+		Messages    []GPTMessage `json:"messages"`
+		MaxTokens   int          `json:"max_tokens"`
+		Temperature float32      `json:"temperature"`
+		Stream      bool         `json:"stream"`
+	}{
+		Model:       p.model,
+		Messages:    messages,
+		MaxTokens:   opts.MaxTokens,
+		Temperature: opts.Temperature,
+		Stream:      stream,
+	})
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, messages []GPTMessage, opts ChatOptions) (string, error) {
+	if p.apiKey == "" {
+		return "", fmt.Errorf("OpenAI API key not set in environment variables")
+	}
+
+	payload, err := p.chatRequest(messages, opts, false)
+	if err != nil {
+		return "", fmt.Errorf("error encoding GPT request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/v1/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("error creating GPT request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending GPT request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("GPT API error: %s", body)
+	}
+
+	var gptResponse struct {
+		Choices []struct {
+			Message GPTMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&gptResponse); err != nil {
+		return "", fmt.Errorf("error decoding GPT response: %w", err)
+	}
+	if len(gptResponse.Choices) == 0 {
+		return "", fmt.Errorf("no GPT response received")
+	}
+
+	return gptResponse.Choices[0].Message.Content, nil
+}
+
+func (p *openAIProvider) ChatStream(ctx context.Context, messages []GPTMessage, opts ChatOptions) (<-chan string, <-chan error) {
+	deltas := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(deltas)
+		defer close(errs)
+
+		if p.apiKey == "" {
+			errs <- fmt.Errorf("OpenAI API key not set in environment variables")
+			return
+		}
+
+		payload, err := p.chatRequest(messages, opts, true)
+		if err != nil {
+			errs <- fmt.Errorf("error encoding GPT request: %w", err)
+			return
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/v1/chat/completions", bytes.NewReader(payload))
+		if err != nil {
+			errs <- fmt.Errorf("error creating GPT request: %w", err)
+			return
+		}
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			errs <- fmt.Errorf("error sending GPT request: %w", err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			errs <- fmt.Errorf("GPT API error: %s", body)
+			return
+		}
+
+		// OpenAI's chat-completion stream is "data: {...}\n\n" lines, ending
+		// in a literal "data: [DONE]".
+		consumeChatStream(ctx, resp.Body, deltas, errs, decodeOpenAISSELine)
+	}()
+
+	return deltas, errs
+}
+
+// decodeOpenAISSELine decodes one "data: {...}" line of an OpenAI-shaped
+// chat-completion SSE stream, shared by openAIProvider and azureProvider
+// since Azure OpenAI Service reuses the same chunk JSON shape.
+func decodeOpenAISSELine(line string) (content string, done bool, skip bool, err error) {
+	if !strings.HasPrefix(line, "data: ") {
+		return "", false, true, nil
+	}
+	payload := strings.TrimPrefix(line, "data: ")
+	if payload == "[DONE]" {
+		return "", true, false, nil
+	}
+
+	var chunk struct {
+		Choices []struct {
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+		return "", false, false, fmt.Errorf("error decoding GPT stream chunk: %w", err)
+	}
+	if len(chunk.Choices) == 0 {
+		return "", false, true, nil
+	}
+	return chunk.Choices[0].Delta.Content, false, false, nil
+}
+
+// --- azureProvider: Azure OpenAI Service. Deployments stand in for model
+// names and auth goes through the api-key header instead of Bearer.
+
+type azureProvider struct {
+	apiKey              string
+	baseURL             string
+	apiVersion          string
+	deployment          string
+	embeddingDeployment string
+}
+
+func (p *azureProvider) url(deployment string, path string) string {
+	return fmt.Sprintf("%s/openai/deployments/%s/%s?api-version=%s", p.baseURL, deployment, path, p.apiVersion)
+}
+
+func (p *azureProvider) EmbeddingModelID() string { return p.embeddingDeployment }
+func (p *azureProvider) ChatModelID() string      { return p.deployment }
+
+func (p *azureProvider) Embed(ctx context.Context, input string) ([]float32, error) {
+	payload, err := json.Marshal(struct {
+		Input string `json:"input"`
+	}{Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding JSON payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url(p.embeddingDeployment, "embeddings"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("api-key", p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to Azure OpenAI: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Azure OpenAI API error: %s", body)
+	}
+
+	var response struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("no embedding data returned")
+	}
+
+	return response.Data[0].Embedding, nil
+}
+
+func (p *azureProvider) chatRequest(messages []GPTMessage, opts ChatOptions, stream bool) ([]byte, error) {
+	return json.Marshal(struct {
+		Messages    []GPTMessage `json:"messages"`
+		MaxTokens   int          `json:"max_tokens"`
+		Temperature float32      `json:"temperature"`
+		Stream      bool         `json:"stream"`
+	}{
+		Messages:    messages,
+		MaxTokens:   opts.MaxTokens,
+		Temperature: opts.Temperature,
+		Stream:      stream,
+	})
+}
+
+func (p *azureProvider) Chat(ctx context.Context, messages []GPTMessage, opts ChatOptions) (string, error) {
+	payload, err := p.chatRequest(messages, opts, false)
+	if err != nil {
+		return "", fmt.Errorf("error encoding GPT request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url(p.deployment, "chat/completions"), bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("error creating GPT request: %w", err)
+	}
+	req.Header.Set("api-key", p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending GPT request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("Azure OpenAI API error: %s", body)
+	}
+
+	var gptResponse struct {
+		Choices []struct {
+			Message GPTMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&gptResponse); err != nil {
+		return "", fmt.Errorf("error decoding GPT response: %w", err)
+	}
+	if len(gptResponse.Choices) == 0 {
+		return "", fmt.Errorf("no GPT response received")
+	}
+
+	return gptResponse.Choices[0].Message.Content, nil
+}
+
+func (p *azureProvider) ChatStream(ctx context.Context, messages []GPTMessage, opts ChatOptions) (<-chan string, <-chan error) {
+	deltas := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(deltas)
+		defer close(errs)
+
+		payload, err := p.chatRequest(messages, opts, true)
+		if err != nil {
+			errs <- fmt.Errorf("error encoding GPT request: %w", err)
+			return
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", p.url(p.deployment, "chat/completions"), bytes.NewReader(payload))
+		if err != nil {
+			errs <- fmt.Errorf("error creating GPT request: %w", err)
+			return
+		}
+		req.Header.Set("api-key", p.apiKey)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			errs <- fmt.Errorf("error sending GPT request: %w", err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			errs <- fmt.Errorf("Azure OpenAI API error: %s", body)
+			return
+		}
+
+		consumeChatStream(ctx, resp.Body, deltas, errs, decodeOpenAISSELine)
+	}()
+
+	return deltas, errs
+}
+
+// --- ollamaProvider: a local Ollama daemon. Request/response shapes differ
+// from OpenAI's, and the streaming transport is newline-delimited JSON
+// objects rather than SSE.
+
+type ollamaProvider struct {
+	baseURL        string
+	model          string
+	embeddingModel string
+}
+
+func (p *ollamaProvider) EmbeddingModelID() string { return p.embeddingModel }
+func (p *ollamaProvider) ChatModelID() string      { return p.model }
+
+func (p *ollamaProvider) Embed(ctx context.Context, input string) ([]float32, error) {
+	payload, err := json.Marshal(struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+	}{Model: p.embeddingModel, Prompt: input})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding JSON payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/api/embeddings", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Ollama API error: %s", body)
+	}
+
+	var response struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	if len(response.Embedding) == 0 {
+		return nil, fmt.Errorf("no embedding data returned")
+	}
+
+	return response.Embedding, nil
+}
+
+type ollamaChatChunk struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done bool `json:"done"`
+}
+
+func (p *ollamaProvider) chatRequest(messages []GPTMessage, stream bool) ([]byte, error) {
+	return json.Marshal(struct {
+		Model    string       `json:"model"`
+		Messages []GPTMessage `json:"messages"`
+		Stream   bool         `json:"stream"`
+	}{Model: p.model, Messages: messages, Stream: stream})
+}
+
+func (p *ollamaProvider) Chat(ctx context.Context, messages []GPTMessage, opts ChatOptions) (string, error) {
+	payload, err := p.chatRequest(messages, false)
+	if err != nil {
+		return "", fmt.Errorf("error encoding GPT request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/api/chat", bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("error creating GPT request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending GPT request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("Ollama API error: %s", body)
+	}
+
+	var chunk ollamaChatChunk
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		return "", fmt.Errorf("error decoding GPT response: %w", err)
+	}
+
+	return chunk.Message.Content, nil
+}
+
+func (p *ollamaProvider) ChatStream(ctx context.Context, messages []GPTMessage, opts ChatOptions) (<-chan string, <-chan error) {
+	deltas := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(deltas)
+		defer close(errs)
+
+		payload, err := p.chatRequest(messages, true)
+		if err != nil {
+			errs <- fmt.Errorf("error encoding GPT request: %w", err)
+			return
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/api/chat", bytes.NewReader(payload))
+		if err != nil {
+			errs <- fmt.Errorf("error creating GPT request: %w", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			errs <- fmt.Errorf("error sending GPT request: %w", err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			errs <- fmt.Errorf("Ollama API error: %s", body)
+			return
+		}
+
+		// Ollama streams one JSON object per line rather than SSE frames.
+		consumeChatStream(ctx, resp.Body, deltas, errs, decodeOllamaLine)
+	}()
+
+	return deltas, errs
+}
+
+// decodeOllamaLine decodes one line of Ollama's newline-delimited chat
+// stream, where each object (not a sentinel line) carries its own done flag.
+func decodeOllamaLine(line string) (content string, done bool, skip bool, err error) {
+	var chunk ollamaChatChunk
+	if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+		return "", false, false, fmt.Errorf("error decoding GPT stream chunk: %w", err)
+	}
+	return chunk.Message.Content, chunk.Done, false, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestChatStreamCancelMidStream is the regression test for chunk0-1: when ctx
+// is cancelled while a delta send is blocked, ChatStream must report ctx.Err()
+// on the errs channel rather than closing it with a nil error, so a cancelled
+// stream is never mistaken for a successful one.
+func TestChatStreamCancelMidStream(t *testing.T) {
+	proceed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"content":"hello"}}]}`+"\n\n")
+		flusher.Flush()
+		<-proceed
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"content":"world"}}]}`+"\n\n")
+		flusher.Flush()
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}))
+	defer server.Close()
+
+	p := &openAIProvider{apiKey: "test", baseURL: server.URL, model: "gpt-4o-mini"}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	deltas, errs := p.ChatStream(ctx, []GPTMessage{{Role: "user", Content: "hi"}}, ChatOptions{})
+
+	select {
+	case d := <-deltas:
+		if d != "hello" {
+			t.Fatalf("first delta = %q, want %q", d, "hello")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for first delta")
+	}
+
+	// Cancel before reading the next delta, then unblock the server so it
+	// tries to send "world" into a channel nobody is reading.
+	cancel()
+	close(proceed)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("errs = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for cancellation error")
+	}
+}
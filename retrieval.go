@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// candidatePoolSize is how many hits each leg of a hybrid search contributes
+// before RRF fuses and truncates them down to the final result count.
+const candidatePoolSize = 10
+
+// retrievedQnA is one row retrieved from the qna table. It does not record
+// which retriever(s) surfaced it; fuseRRF only logs that.
+type retrievedQnA struct {
+	ID       int
+	Question string
+	Answer   string
+}
+
+// retrievalModeFromEnv reads RETRIEVAL_MODE ("vector", "bm25", or "hybrid"),
+// defaulting to "hybrid".
+func retrievalModeFromEnv() string {
+	switch mode := os.Getenv("RETRIEVAL_MODE"); mode {
+	case "vector", "bm25", "hybrid":
+		return mode
+	case "":
+		return "hybrid"
+	default:
+		log.Printf("Unknown RETRIEVAL_MODE %q, falling back to hybrid", mode)
+		return "hybrid"
+	}
+}
+
+// rrfKFromEnv reads the RRF_K constant used to dampen the influence of
+// low ranks in reciprocal rank fusion, defaulting to 60.
+func rrfKFromEnv() int {
+	value := os.Getenv("RRF_K")
+	if value == "" {
+		return 60
+	}
+	k, err := strconv.Atoi(value)
+	if err != nil || k <= 0 {
+		log.Printf("Invalid RRF_K %q, using default 60", value)
+		return 60
+	}
+	return k
+}
+
+// retrieveRelevantQnA finds the qna rows most relevant to query, routing
+// through vector search, BM25 full-text search, or both fused with
+// reciprocal rank fusion depending on RETRIEVAL_MODE.
+func retrieveRelevantQnA(ctx context.Context, query string, embeddingJSON string, limit int) ([]retrievedQnA, error) {
+	switch retrievalModeFromEnv() {
+	case "vector":
+		return vectorSearch(ctx, embeddingJSON, limit)
+	case "bm25":
+		return bm25Search(ctx, query, limit)
+	default:
+		var vectorResults, bm25Results []retrievedQnA
+		g, gctx := errgroup.WithContext(ctx)
+		g.Go(func() error {
+			var err error
+			vectorResults, err = vectorSearch(gctx, embeddingJSON, candidatePoolSize)
+			return err
+		})
+		g.Go(func() error {
+			var err error
+			bm25Results, err = bm25Search(gctx, query, candidatePoolSize)
+			return err
+		})
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+		return fuseRRF(rrfKFromEnv(), limit, map[string][]retrievedQnA{
+			"vector": vectorResults,
+			"bm25":   bm25Results,
+		}), nil
+	}
+}
+
+func vectorSearch(ctx context.Context, embeddingJSON string, limit int) ([]retrievedQnA, error) {
+	rows, err := dbConn.Query(ctx, `
+		SELECT id, question, answer
+		FROM qna
+		WHERE embedding <-> $1 < 1
+		ORDER BY embedding <-> $1
+		LIMIT $2;`, embeddingJSON, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error performing vector search: %w", err)
+	}
+	defer rows.Close()
+
+	var results []retrievedQnA
+	for rows.Next() {
+		var r retrievedQnA
+		if err := rows.Scan(&r.ID, &r.Question, &r.Answer); err != nil {
+			return nil, fmt.Errorf("error scanning vector search row: %w", err)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func bm25Search(ctx context.Context, query string, limit int) ([]retrievedQnA, error) {
+	rows, err := dbConn.Query(ctx, `
+		SELECT id, question, answer
+		FROM qna
+		WHERE tsv @@ plainto_tsquery('simple', $1)
+		ORDER BY ts_rank_cd(tsv, plainto_tsquery('simple', $1)) DESC
+		LIMIT $2;`, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error performing BM25 search: %w", err)
+	}
+	defer rows.Close()
+
+	var results []retrievedQnA
+	for rows.Next() {
+		var r retrievedQnA
+		if err := rows.Scan(&r.ID, &r.Question, &r.Answer); err != nil {
+			return nil, fmt.Errorf("error scanning BM25 search row: %w", err)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// fuseRRF combines the ranked lists in sources via Reciprocal Rank Fusion
+// (score(d) = Σ 1/(k + rank_i(d))) and returns the top `limit` documents,
+// logging which retriever(s) contributed each one.
+func fuseRRF(k int, limit int, sources map[string][]retrievedQnA) []retrievedQnA {
+	type fused struct {
+		item    retrievedQnA
+		score   float64
+		sources []string
+	}
+
+	byID := make(map[int]*fused)
+	for sourceName, list := range sources {
+		for rank, item := range list {
+			entry, ok := byID[item.ID]
+			if !ok {
+				entry = &fused{item: item}
+				byID[item.ID] = entry
+			}
+			entry.score += 1.0 / float64(k+rank+1)
+			entry.sources = append(entry.sources, sourceName)
+		}
+	}
+
+	ranked := make([]*fused, 0, len(byID))
+	for _, entry := range byID {
+		ranked = append(ranked, entry)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].item.ID < ranked[j].item.ID
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	results := make([]retrievedQnA, len(ranked))
+	for i, entry := range ranked {
+		log.Printf("retrieval: qna id=%d score=%.4f sources=%v", entry.item.ID, entry.score, entry.sources)
+		results[i] = entry.item
+	}
+	return results
+}
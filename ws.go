@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Connection timeouts, tunable via env so deployments can trade faster dead-
+// peer detection against tolerance for slow clients/networks.
+var (
+	wsReadTimeout  = envDuration("WS_READ_TIMEOUT", 60*time.Second)
+	wsWriteTimeout = envDuration("WS_WRITE_TIMEOUT", 10*time.Second)
+	wsIdleTimeout  = envDuration("WS_IDLE_TIMEOUT", 90*time.Second)
+)
+
+// maxConcurrentChatsPerConn bounds how many handleRAG calls one connection
+// can have in flight at once: a client is free to fire several "chat" frames
+// back-to-back (that's what makes per-message cancellation useful), but
+// without a cap a single connection could run the embedding/retrieval/GPT
+// pipeline an unbounded number of times in parallel against the shared pool.
+const maxConcurrentChatsPerConn = 4
+
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s=%q, using default %s: %s", key, value, def, err)
+		return def
+	}
+	return d
+}
+
+// wsProtocolVersion is the current /chat message schema version, echoed back
+// on every server frame so clients can detect a breaking change.
+const wsProtocolVersion = 1
+
+// Error codes returned in error frames.
+const (
+	errBadJSON      = "BAD_JSON"
+	errMissingQuery = "MISSING_QUERY"
+	errUnknownType  = "UNKNOWN_TYPE"
+)
+
+// wsEnvelope is just enough of a client frame to route it: every frame has a
+// "type", and a "cancel" frame only needs the "id" on top of that.
+type wsEnvelope struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// chatRequest is the versioned schema for a client "chat" frame:
+// {"v":1,"type":"chat","id":"...","query":"...","conversation_id":"..."}.
+// id is echoed back on every reply so a client can correlate concurrent
+// requests; conversation_id is optional and overrides the conversation
+// resolved for the connection (see resolveConversationID).
+type chatRequest struct {
+	V              int    `json:"v"`
+	Type           string `json:"type"`
+	ID             string `json:"id"`
+	Query          string `json:"query"`
+	ConversationID string `json:"conversation_id"`
+}
+
+// chatResponse is the versioned schema for every server frame: type is one
+// of "chat" (ack), "delta", "done", "cancelled", or "error".
+type chatResponse struct {
+	V              int    `json:"v"`
+	Type           string `json:"type"`
+	ID             string `json:"id,omitempty"`
+	Content        string `json:"content,omitempty"`
+	Answer         string `json:"answer,omitempty"`
+	Recommend1     string `json:"recommend1,omitempty"`
+	Recommend2     string `json:"recommend2,omitempty"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	References     []int  `json:"references,omitempty"`
+	Code           string `json:"code,omitempty"`
+	Message        string `json:"message,omitempty"`
+}
+
+func errorResponse(id string, code string, message string) chatResponse {
+	return chatResponse{V: wsProtocolVersion, Type: "error", ID: id, Code: code, Message: message}
+}
+
+func chatHandler(res http.ResponseWriter, req *http.Request, provider LLMProvider) {
+	conn, err := upgrader.Upgrade(res, req, nil)
+	if err != nil {
+		log.Printf("WebSocket Upgrade failed: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	log.Println("WebSocket connection established")
+
+	// connCtx is cancelled the moment the connection is torn down (idle
+	// timeout, read/write error, or the client disconnecting), so any
+	// in-flight OpenAI/Postgres call started on behalf of this connection is
+	// cancelled along with it.
+	connCtx, connCancel := context.WithCancel(req.Context())
+	defer connCancel()
+
+	session_id, ok := req.Context().Value("session_id").(string)
+	if !ok || session_id == "" {
+		log.Println("Failed to retrieve session ID from context")
+	}
+
+	conversationID, err := resolveConversationID(connCtx, session_id, req.URL.Query().Get("conversation_id"))
+	if err != nil {
+		log.Printf("Error resolving conversation: %s", err)
+		conn.WriteJSON(errorResponse("", "CONVERSATION_FAILED", err.Error()))
+		return
+	}
+
+	var writeMu sync.Mutex
+	writeJSON := func(v interface{}) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteJSON(v); err != nil {
+			log.Printf("Error writing message: %s", err)
+		}
+	}
+	closeGoingAway := func(cause error) {
+		log.Printf("Closing WebSocket connection: %s", cause)
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		deadline := time.Now().Add(wsWriteTimeout)
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, cause.Error()), deadline)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+		return nil
+	})
+
+	pingTicker := time.NewTicker(wsIdleTimeout / 2)
+	defer pingTicker.Stop()
+	go func() {
+		for {
+			select {
+			case <-pingTicker.C:
+				writeMu.Lock()
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				err := conn.WriteMessage(websocket.PingMessage, nil)
+				writeMu.Unlock()
+				if err != nil {
+					log.Printf("Error sending ping: %s", err)
+					connCancel()
+					return
+				}
+			case <-connCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var pendingMu sync.Mutex
+	pending := make(map[string]context.CancelFunc)
+	inFlight := make(chan struct{}, maxConcurrentChatsPerConn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			closeGoingAway(err)
+			break
+		}
+		log.Printf("Received: %s", message)
+
+		var envelope wsEnvelope
+		if err := json.Unmarshal(message, &envelope); err != nil {
+			writeJSON(errorResponse("", errBadJSON, err.Error()))
+			continue
+		}
+
+		switch envelope.Type {
+		case "cancel":
+			pendingMu.Lock()
+			cancel, ok := pending[envelope.ID]
+			pendingMu.Unlock()
+			if ok {
+				cancel()
+			}
+			continue
+		case "chat":
+			// handled below
+		default:
+			writeJSON(errorResponse(envelope.ID, errUnknownType, fmt.Sprintf("unknown type %q", envelope.Type)))
+			continue
+		}
+
+		var chatReq chatRequest
+		if err := json.Unmarshal(message, &chatReq); err != nil {
+			writeJSON(errorResponse(envelope.ID, errBadJSON, err.Error()))
+			continue
+		}
+		if chatReq.Query == "" {
+			writeJSON(errorResponse(chatReq.ID, errMissingQuery, "query is required"))
+			continue
+		}
+
+		id := chatReq.ID
+		if id == "" {
+			generated, err := generateMessageID()
+			if err != nil {
+				log.Printf("Error generating message ID: %s", err)
+				continue
+			}
+			id = generated
+		}
+
+		msgConversationID := conversationID
+		if chatReq.ConversationID != "" {
+			owned, err := conversationBelongsToSession(connCtx, chatReq.ConversationID, session_id)
+			if err != nil {
+				log.Printf("Error checking conversation ownership: %s", err)
+				writeJSON(errorResponse(id, "CONVERSATION_NOT_FOUND", err.Error()))
+				continue
+			}
+			if !owned {
+				writeJSON(errorResponse(id, "CONVERSATION_NOT_FOUND", fmt.Sprintf("conversation %q not found", chatReq.ConversationID)))
+				continue
+			}
+			msgConversationID = chatReq.ConversationID
+		}
+
+		streamCtx, cancel := context.WithCancel(connCtx)
+		pendingMu.Lock()
+		pending[id] = cancel
+		pendingMu.Unlock()
+
+		go func(query string, conversationID string) {
+			defer func() {
+				pendingMu.Lock()
+				delete(pending, id)
+				pendingMu.Unlock()
+				cancel()
+			}()
+
+			select {
+			case inFlight <- struct{}{}:
+				defer func() { <-inFlight }()
+			case <-streamCtx.Done():
+				writeJSON(chatResponse{V: wsProtocolVersion, Type: "cancelled", ID: id})
+				return
+			}
+
+			writeJSON(chatResponse{V: wsProtocolVersion, Type: "chat", ID: id})
+
+			answer, recommend1, recommend2, qnaIDs, err := handleRAG(streamCtx, provider, conversationID, query, func(delta string) {
+				writeJSON(chatResponse{V: wsProtocolVersion, Type: "delta", ID: id, Content: delta})
+			})
+			if err != nil {
+				if streamCtx.Err() != nil {
+					writeJSON(chatResponse{V: wsProtocolVersion, Type: "cancelled", ID: id})
+					return
+				}
+				log.Printf("Error in RAG process: %s", err)
+				writeJSON(errorResponse(id, "RAG_FAILED", err.Error()))
+				return
+			}
+
+			writeJSON(chatResponse{
+				V:              wsProtocolVersion,
+				Type:           "done",
+				ID:             id,
+				Answer:         answer,
+				Recommend1:     recommend1,
+				Recommend2:     recommend2,
+				ConversationID: conversationID,
+				References:     qnaIDs,
+			})
+		}(chatReq.Query, msgConversationID)
+	}
+}
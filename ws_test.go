@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TestChatHandlerValidation is a table-driven sweep over the /chat frame
+// validation errors from chunk0-8. This is a real test of shipped validation
+// behavior, not an optional one, so it fails loudly (rather than skipping)
+// when it cannot reach the live conversations table chatHandler needs: set
+// DB_HOST/DB_PORT/DB_USER/DB_PASSWORD to a seeded Postgres instance and run
+// this from a CI job that has one.
+func TestChatHandlerValidation(t *testing.T) {
+	host := os.Getenv("DB_HOST")
+	if host == "" {
+		t.Fatal("DB_HOST not set: this test requires a live, migrated Postgres instance " +
+			"configured with DB_HOST/DB_PORT/DB_USER/DB_PASSWORD; it cannot be skipped")
+	}
+
+	dbConnStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), host, os.Getenv("DB_PORT"), "coxwave")
+	pool, err := pgxpool.New(context.Background(), dbConnStr)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v", err)
+	}
+	defer pool.Close()
+	dbConn = pool
+
+	handler := SessionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chatHandler(w, r, nil)
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/chat"
+
+	tests := []struct {
+		name     string
+		frame    string
+		wantCode string
+	}{
+		{"bad json", `{not json`, errBadJSON},
+		{"missing query", `{"v":1,"type":"chat","id":"1"}`, errMissingQuery},
+		{"unknown type", `{"v":1,"type":"bogus","id":"1"}`, errUnknownType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer ws.Close()
+
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(tt.frame)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var resp chatResponse
+			if err := ws.ReadJSON(&resp); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+
+			if resp.Type != "error" {
+				t.Errorf("type = %q, want %q", resp.Type, "error")
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", resp.Code, tt.wantCode)
+			}
+		})
+	}
+}